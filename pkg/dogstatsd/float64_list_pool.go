@@ -0,0 +1,32 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import "sync"
+
+// float64ListPool pools reusable float64 slices used while parsing metric
+// sample values, to avoid reallocating on every dogstatsd packet.
+type float64ListPool struct {
+	pool *sync.Pool
+}
+
+func newFloat64ListPool() *float64ListPool {
+	return &float64ListPool{
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return make([]float64, 0, 1)
+			},
+		},
+	}
+}
+
+func (p *float64ListPool) get() []float64 {
+	return p.pool.Get().([]float64)[:0]
+}
+
+func (p *float64ListPool) put(list []float64) {
+	p.pool.Put(list) //nolint:staticcheck
+}
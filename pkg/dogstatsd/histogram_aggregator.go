@@ -0,0 +1,125 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// histogramAggregator holds one sampledHistogram per (metric name, tag set)
+// key for every sampledHistogramType metricSample seen since the last flush.
+// It's the downstream consumer parseMetricSample's output feeds into for `H`
+// lines, the way a bucketed histogram would aggregate `h` lines.
+type histogramAggregator struct {
+	histograms map[string]*sampledHistogram
+	// quantiles remembers the last `q:` suffix requested per key, so flush
+	// can emit exact values for those quantiles alongside the fixed set.
+	quantiles map[string][]float64
+}
+
+func newHistogramAggregator() *histogramAggregator {
+	return &histogramAggregator{
+		histograms: make(map[string]*sampledHistogram),
+		quantiles:  make(map[string][]float64),
+	}
+}
+
+// sample routes a parsed sampledHistogramType metricSample into its
+// per-(metric,tag-set) sampledHistogram, creating it on first use. Samples
+// of any other metricType are ignored.
+func (a *histogramAggregator) sample(ms metricSample) {
+	if ms.metricType != sampledHistogramType {
+		return
+	}
+
+	key := histogramKey(ms.name, ms.tags)
+	h, ok := a.histograms[key]
+	if !ok {
+		h = newSampledHistogram()
+		a.histograms[key] = h
+	}
+	h.sample(ms.value)
+
+	if len(ms.quantiles) > 0 {
+		a.quantiles[key] = ms.quantiles
+	}
+}
+
+// flushedHistogram is one (metric,tag-set) key's result for a flush
+// interval.
+type flushedHistogram struct {
+	name   string
+	tags   []string
+	gauges sampledHistogramGauges
+	// custom maps each quantile requested via a q: suffix to its exact
+	// value, computed from the reservoir rather than approximated from the
+	// digest, since there are normally too few of them to warrant a
+	// dedicated centroid.
+	custom map[float64]float64
+}
+
+// asGauges flattens a flushedHistogram into the `<name>.<suffix>` gauges a
+// forwarder would emit, e.g. `latency.p50`, `latency.p95`, and
+// `latency.p90` for a requested custom quantile of 0.9.
+func (fh flushedHistogram) asGauges() map[string]float64 {
+	out := map[string]float64{
+		fh.name + ".p50":  fh.gauges.p50,
+		fh.name + ".p95":  fh.gauges.p95,
+		fh.name + ".p99":  fh.gauges.p99,
+		fh.name + ".p999": fh.gauges.p999,
+		fh.name + ".max":  fh.gauges.max,
+		fh.name + ".avg":  fh.gauges.avg,
+	}
+	for q, v := range fh.custom {
+		out[fmt.Sprintf("%s.p%g", fh.name, q*100)] = v
+	}
+	return out
+}
+
+// flush drains every histogram accumulated since the last flush into one
+// flushedHistogram per (metric,tag-set) key, then resets the aggregator for
+// the next interval.
+func (a *histogramAggregator) flush() []flushedHistogram {
+	out := make([]flushedHistogram, 0, len(a.histograms))
+	for key, h := range a.histograms {
+		name, tags := splitHistogramKey(key)
+
+		var custom map[float64]float64
+		if qs := a.quantiles[key]; len(qs) > 0 {
+			// computed before h.flush(), which resets the reservoir these
+			// exact quantiles are read from
+			custom = make(map[float64]float64, len(qs))
+			for _, q := range qs {
+				custom[q] = h.reservoir.quantile(q)
+			}
+		}
+
+		out = append(out, flushedHistogram{
+			name:   name,
+			tags:   tags,
+			gauges: h.flush(),
+			custom: custom,
+		})
+	}
+
+	a.histograms = make(map[string]*sampledHistogram)
+	a.quantiles = make(map[string][]float64)
+	return out
+}
+
+func histogramKey(name string, tags []string) string {
+	return name + "|" + strings.Join(tags, ",")
+}
+
+func splitHistogramKey(key string) (string, []string) {
+	i := strings.IndexByte(key, '|')
+	name, rawTags := key[:i], key[i+1:]
+	if rawTags == "" {
+		return name, nil
+	}
+	return name, strings.Split(rawTags, ",")
+}
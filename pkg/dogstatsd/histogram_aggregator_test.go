@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetricSampleSampledHistogram(t *testing.T) {
+	parser := newParser(newFloat64ListPool())
+
+	ms, err := parser.parseMetricSample([]byte("latency:42|H|@0.1|#env:prod|q:0.5,0.9"))
+	assert.NoError(t, err)
+	assert.Equal(t, "latency", ms.name)
+	assert.Equal(t, 42.0, ms.value)
+	assert.Equal(t, sampledHistogramType, ms.metricType)
+	assert.Equal(t, 0.1, ms.sampleRate)
+	assert.Equal(t, []string{"env:prod"}, ms.tags)
+	assert.Equal(t, []float64{0.5, 0.9}, ms.quantiles)
+}
+
+func TestParseMetricSampleDefaults(t *testing.T) {
+	parser := newParser(newFloat64ListPool())
+
+	ms, err := parser.parseMetricSample([]byte("song.length:240|g"))
+	assert.NoError(t, err)
+	assert.Equal(t, gaugeType, ms.metricType)
+	assert.Equal(t, 1.0, ms.sampleRate)
+	assert.Nil(t, ms.tags)
+	assert.Nil(t, ms.quantiles)
+}
+
+func TestHistogramAggregatorFlushesByMetricAndTags(t *testing.T) {
+	parser := newParser(newFloat64ListPool())
+	agg := newHistogramAggregator()
+
+	for i := 1; i <= 100; i++ {
+		ms, err := parser.parseMetricSample([]byte("latency:" + strconv.Itoa(i) + "|H|#env:prod"))
+		assert.NoError(t, err)
+		agg.sample(ms)
+	}
+	ms, err := parser.parseMetricSample([]byte("latency:999|H|#env:staging|q:0.9"))
+	assert.NoError(t, err)
+	agg.sample(ms)
+
+	flushed := agg.flush()
+	assert.Len(t, flushed, 2)
+
+	byTags := make(map[string]flushedHistogram, len(flushed))
+	for _, fh := range flushed {
+		byTags[histogramKey(fh.name, fh.tags)] = fh
+	}
+
+	prod := byTags[histogramKey("latency", []string{"env:prod"})]
+	assert.InDelta(t, 50, prod.gauges.p50, 10)
+	gauges := prod.asGauges()
+	assert.Contains(t, gauges, "latency.p50")
+
+	staging := byTags[histogramKey("latency", []string{"env:staging"})]
+	assert.InDelta(t, 999, staging.custom[0.9], 1)
+	assert.Contains(t, staging.asGauges(), "latency.p90")
+
+	assert.Empty(t, agg.histograms)
+}
@@ -0,0 +1,220 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// messageType tells us whether a raw dogstatsd packet is a metric sample, a
+// service check or an event.
+type messageType int
+
+const (
+	metricSampleType messageType = iota
+	serviceCheckType
+	eventType
+)
+
+// findMessageType inspects the prefix of a raw dogstatsd message to decide
+// how it should be parsed.
+func findMessageType(message []byte) messageType {
+	if len(message) >= 3 {
+		switch string(message[0:3]) {
+		case "_sc":
+			return serviceCheckType
+		case "_e{":
+			return eventType
+		}
+	}
+	return metricSampleType
+}
+
+// metricType identifies the dogstatsd metric type character(s) found between
+// the two pipes of a metric sample line, e.g. `metric:1|g`.
+type metricType int
+
+const (
+	gaugeType metricType = iota
+	counterType
+	histogramType
+	timerType
+	setType
+	distributionType
+	// sampledHistogramType is the `H` (capital) sub-type: like histogramType
+	// but backed by reservoir sampling and a streaming t-digest instead of
+	// fixed buckets, so high quantiles stay accurate under aggressive
+	// @sample_rate. See sampledHistogram.
+	sampledHistogramType
+)
+
+// parseMetricType maps the raw metric type token of a dogstatsd line to a
+// metricType.
+func parseMetricType(raw []byte) (metricType, error) {
+	switch string(raw) {
+	case "g":
+		return gaugeType, nil
+	case "c":
+		return counterType, nil
+	case "h":
+		return histogramType, nil
+	case "H":
+		return sampledHistogramType, nil
+	case "ms":
+		return timerType, nil
+	case "s":
+		return setType, nil
+	case "d":
+		return distributionType, nil
+	default:
+		return 0, fmt.Errorf("invalid metric type: %q", raw)
+	}
+}
+
+// parser turns raw dogstatsd packets into their parsed representation.
+type parser struct {
+	float64List *float64ListPool
+}
+
+func newParser(float64List *float64ListPool) *parser {
+	return &parser{
+		float64List: float64List,
+	}
+}
+
+// parseTags splits a raw `#tag1,tag2:value` tag block into individual tags.
+func (p *parser) parseTags(rawTags []byte) []string {
+	if len(rawTags) == 0 {
+		return nil
+	}
+	return strings.Split(string(rawTags), ",")
+}
+
+// parseFloat64 parses a single dogstatsd value, e.g. the `42` in `latency:42|H`.
+func parseFloat64(raw []byte) (float64, error) {
+	return strconv.ParseFloat(string(raw), 64)
+}
+
+// parseFloat64List parses a colon-separated list of values, e.g. a batch
+// metric sample `metric:1:2:3|g`. Empty segments (consecutive or leading
+// colons) are skipped rather than treated as errors.
+func parseFloat64List(raw []byte, floats []float64) ([]float64, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("no value found")
+	}
+	for _, part := range strings.Split(string(raw), ":") {
+		if part == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		floats = append(floats, f)
+	}
+	return floats, nil
+}
+
+// parseInt64 parses a single dogstatsd integer value, e.g. a set/counter delta.
+func parseInt64(raw []byte) (int64, error) {
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
+// parseQuantiles parses an optional `q:0.5,0.9,0.99` quantile-request suffix
+// on a sampledHistogramType line, returning the requested quantiles in (0,1].
+func parseQuantiles(raw []byte) ([]float64, error) {
+	if len(raw) < 2 || raw[0] != 'q' || raw[1] != ':' {
+		return nil, fmt.Errorf("invalid quantile suffix: %q", raw)
+	}
+	var quantiles []float64
+	for _, part := range strings.Split(string(raw[2:]), ",") {
+		if part == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		if q <= 0 || q > 1 {
+			return nil, fmt.Errorf("quantile out of range (0,1]: %v", q)
+		}
+		quantiles = append(quantiles, q)
+	}
+	if len(quantiles) == 0 {
+		return nil, errors.New("no quantile found")
+	}
+	return quantiles, nil
+}
+
+// metricSample is a fully parsed dogstatsd metric sample line, e.g.
+// `latency:42|H|@0.1|#env:prod|q:0.5,0.9`.
+type metricSample struct {
+	name       string
+	value      float64
+	metricType metricType
+	sampleRate float64
+	tags       []string
+	// quantiles holds the optional `q:` suffix requested on a
+	// sampledHistogramType line; nil for every other metric type.
+	quantiles []float64
+}
+
+// parseMetricSample parses a complete `name:value|type[|@sample_rate][|#tags][|q:quantiles]`
+// line into a metricSample, dispatching each field to the fragment parsers
+// above. It's the entry point findMessageType routes metricSampleType lines
+// to, including the `H` sampledHistogramType sub-type.
+func (p *parser) parseMetricSample(raw []byte) (metricSample, error) {
+	colon := bytes.IndexByte(raw, ':')
+	if colon == -1 {
+		return metricSample{}, fmt.Errorf("missing name/value separator: %q", raw)
+	}
+
+	parts := bytes.Split(raw[colon+1:], []byte("|"))
+	if len(parts) < 2 {
+		return metricSample{}, fmt.Errorf("missing metric type: %q", raw)
+	}
+
+	value, err := parseFloat64(parts[0])
+	if err != nil {
+		return metricSample{}, fmt.Errorf("invalid value: %w", err)
+	}
+
+	mt, err := parseMetricType(parts[1])
+	if err != nil {
+		return metricSample{}, err
+	}
+
+	ms := metricSample{
+		name:       string(raw[:colon]),
+		value:      value,
+		metricType: mt,
+		sampleRate: 1,
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case len(part) > 1 && part[0] == '@':
+			rate, err := parseFloat64(part[1:])
+			if err != nil {
+				return metricSample{}, fmt.Errorf("invalid sample rate: %w", err)
+			}
+			ms.sampleRate = rate
+		case len(part) > 0 && part[0] == '#':
+			ms.tags = p.parseTags(part[1:])
+		case mt == sampledHistogramType && len(part) > 1 && part[0] == 'q' && part[1] == ':':
+			quantiles, err := parseQuantiles(part)
+			if err != nil {
+				return metricSample{}, err
+			}
+			ms.quantiles = quantiles
+		}
+	}
+	return ms, nil
+}
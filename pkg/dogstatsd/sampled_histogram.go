@@ -0,0 +1,247 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	// sampledHistogramReservoirSize is the number of raw values kept per
+	// (metric,tag-set) between flushes, enough to recompute exact quantiles
+	// over the flush interval without retaining the full sample stream.
+	sampledHistogramReservoirSize = 1000
+	// sampledHistogramDigestCompression is the t-digest compression: a larger
+	// value keeps more, smaller centroids. It does not cap the centroid count,
+	// which grows with the logarithm of the number of raw samples (about 300
+	// centroids for 1,000 samples and 800 for 1,000,000 at this setting).
+	sampledHistogramDigestCompression = 100
+)
+
+// reservoir is a fixed-size Vitter's algorithm R reservoir: it keeps an
+// unbiased uniform sample of up to size values out of an arbitrarily long
+// stream, so exact quantiles can be recomputed over the flush window.
+type reservoir struct {
+	size    int
+	samples []float64
+	seen    int64
+	rng     *rand.Rand
+}
+
+func newReservoir(size int) *reservoir {
+	return &reservoir{
+		size:    size,
+		samples: make([]float64, 0, size),
+		rng:     rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+func (r *reservoir) add(v float64) {
+	r.seen++
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, v)
+		return
+	}
+	if i := r.rng.Int63n(r.seen); i < int64(r.size) {
+		r.samples[i] = v
+	}
+}
+
+func (r *reservoir) reset() {
+	r.samples = r.samples[:0]
+	r.seen = 0
+}
+
+// quantile returns the sample at quantile q (0,1] over the samples currently
+// held: the one at rank floor(q*n), clamped to [1,n], of a sorted copy.
+func (r *reservoir) quantile(q float64) float64 {
+	n := len(r.samples)
+	if n == 0 {
+		return 0
+	}
+	sorted := make([]float64, n)
+	copy(sorted, r.samples)
+	sort.Float64s(sorted)
+
+	idx := int(q*float64(n)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= n {
+		idx = n - 1
+	}
+	return sorted[idx]
+}
+
+// centroid is one weighted mean in a tdigest.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tdigest is a streaming, mergeable digest used to compute accurate
+// high-quantile (p95/p99/p999) estimates with far fewer centroids than raw
+// samples; the centroid count still grows logarithmically with the number of
+// samples fed into it. Centroids near the
+// tails are kept small (close to exact), while centroids near the median are
+// allowed to absorb many more samples, since that's where accuracy matters
+// least for our use case.
+type tdigest struct {
+	compression float64
+	centroids   []centroid
+	count       float64
+	unmerged    int
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression}
+}
+
+// add folds one raw value into the digest. Centroids are only recompressed
+// periodically (see compress), so this is cheap on the hot path.
+func (d *tdigest) add(value float64) {
+	d.centroids = append(d.centroids, centroid{mean: value, weight: 1})
+	d.count++
+	d.unmerged++
+	if d.unmerged > int(4*d.compression) {
+		d.compress()
+	}
+}
+
+// merge absorbs another digest's centroids, e.g. when combining the
+// per-DogStatsD-instance digests of a multi-instance aggregation.
+func (d *tdigest) merge(other *tdigest) {
+	if other == nil {
+		return
+	}
+	d.centroids = append(d.centroids, other.centroids...)
+	d.count += other.count
+	d.unmerged += len(other.centroids)
+	d.compress()
+}
+
+// compress sorts all centroids by mean and merges adjacent ones as long as
+// doing so keeps each centroid's share of the total weight within the scale
+// function k(q) = 4*q*(1-q)/compression, which is what keeps the tails sharp
+// while the body of the distribution compacts aggressively.
+func (d *tdigest) compress() {
+	if len(d.centroids) == 0 {
+		d.unmerged = 0
+		return
+	}
+	sort.Slice(d.centroids, func(i, j int) bool {
+		return d.centroids[i].mean < d.centroids[j].mean
+	})
+
+	merged := make([]centroid, 0, int(d.compression)+1)
+	cur := d.centroids[0]
+	soFar := cur.weight
+
+	for _, c := range d.centroids[1:] {
+		q := (soFar + c.weight/2) / d.count
+		maxWeight := 4 * d.count * q * (1 - q) / d.compression
+		if cur.weight+c.weight <= maxWeight || maxWeight <= 0 {
+			cur.mean = (cur.mean*cur.weight + c.mean*c.weight) / (cur.weight + c.weight)
+			cur.weight += c.weight
+		} else {
+			merged = append(merged, cur)
+			cur = c
+		}
+		soFar += c.weight
+	}
+	merged = append(merged, cur)
+
+	d.centroids = merged
+	d.unmerged = 0
+}
+
+// quantile returns the estimated value at quantile q (0,1]: the mean of the
+// first centroid whose cumulative weight reaches q*count. It does not
+// interpolate between neighbouring centroids.
+func (d *tdigest) quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return 0
+	}
+	if len(d.centroids) == 1 {
+		return d.centroids[0].mean
+	}
+
+	target := q * d.count
+	var soFar float64
+	for i, c := range d.centroids {
+		soFar += c.weight
+		if soFar >= target || i == len(d.centroids)-1 {
+			return c.mean
+		}
+	}
+	return d.centroids[len(d.centroids)-1].mean
+}
+
+func (d *tdigest) max() float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return 0
+	}
+	return d.centroids[len(d.centroids)-1].mean
+}
+
+func (d *tdigest) avg() float64 {
+	if d.count == 0 {
+		return 0
+	}
+	var sum float64
+	for _, c := range d.centroids {
+		sum += c.mean * c.weight
+	}
+	return sum / d.count
+}
+
+// sampledHistogram aggregates one (metric,tag-set) sampledHistogramType
+// series between flushes. It feeds every value into both a bounded
+// reservoir, for exact quantiles over the flush window, and a tdigest, so
+// merging several DogStatsD instances' state stays cheap. It replaces the
+// bucketed histogram for metrics where @sample_rate is aggressive enough
+// that bucket boundaries would otherwise distort p95/p99/p999.
+type sampledHistogram struct {
+	reservoir *reservoir
+	digest    *tdigest
+}
+
+func newSampledHistogram() *sampledHistogram {
+	return &sampledHistogram{
+		reservoir: newReservoir(sampledHistogramReservoirSize),
+		digest:    newTDigest(sampledHistogramDigestCompression),
+	}
+}
+
+func (h *sampledHistogram) sample(value float64) {
+	h.reservoir.add(value)
+	h.digest.add(value)
+}
+
+// sampledHistogramGauges are the gauges emitted for a sampledHistogram on
+// flush, derived from its tdigest.
+type sampledHistogramGauges struct {
+	p50, p95, p99, p999, max, avg float64
+}
+
+// flush computes the .p50/.p95/.p99/.p999/.max/.avg gauges for the current
+// flush interval and clears both the reservoir and the digest.
+func (h *sampledHistogram) flush() sampledHistogramGauges {
+	gauges := sampledHistogramGauges{
+		p50:  h.digest.quantile(0.50),
+		p95:  h.digest.quantile(0.95),
+		p99:  h.digest.quantile(0.99),
+		p999: h.digest.quantile(0.999),
+		max:  h.digest.max(),
+		avg:  h.digest.avg(),
+	}
+	h.reservoir.reset()
+	h.digest = newTDigest(sampledHistogramDigestCompression)
+	return gauges
+}
@@ -0,0 +1,98 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package dogstatsd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifySampledHistogram(t *testing.T) {
+	metricSample := []byte("latency:42|H|@0.1")
+	messageType := findMessageType(metricSample)
+	assert.Equal(t, metricSampleType, messageType)
+
+	metricType, err := parseMetricType([]byte("H"))
+	assert.NoError(t, err)
+	assert.Equal(t, sampledHistogramType, metricType)
+}
+
+func TestParseQuantiles(t *testing.T) {
+	quantiles, err := parseQuantiles([]byte("q:0.5,0.9,0.99"))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.5, 0.9, 0.99}, quantiles)
+
+	_, err = parseQuantiles([]byte("q:"))
+	assert.Error(t, err)
+
+	_, err = parseQuantiles([]byte("q:1.5"))
+	assert.Error(t, err)
+
+	_, err = parseQuantiles([]byte("notq:0.5"))
+	assert.Error(t, err)
+}
+
+func TestTDigestSingleInstanceQuantiles(t *testing.T) {
+	d := newTDigest(100)
+	for i := 1; i <= 1000; i++ {
+		d.add(float64(i))
+	}
+
+	assert.InDelta(t, 500, d.quantile(0.50), 25)
+	assert.InDelta(t, 950, d.quantile(0.95), 15)
+	assert.InDelta(t, 990, d.quantile(0.99), 10)
+	assert.InDelta(t, 1000, d.max(), 1)
+}
+
+// TestTDigestMerge checks that merging two per-instance digests built from
+// disjoint halves of the same distribution produces quantile estimates
+// close to what a single digest fed the whole distribution would, which is
+// the property multi-DogStatsD-instance aggregation relies on.
+func TestTDigestMerge(t *testing.T) {
+	a := newTDigest(100)
+	b := newTDigest(100)
+	whole := newTDigest(100)
+
+	for i := 1; i <= 500; i++ {
+		a.add(float64(i))
+		whole.add(float64(i))
+	}
+	for i := 501; i <= 1000; i++ {
+		b.add(float64(i))
+		whole.add(float64(i))
+	}
+
+	a.merge(b)
+
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		got := a.quantile(q)
+		want := whole.quantile(q)
+		assert.InDelta(t, want, got, math.Max(want*0.1, 10), "quantile %v diverged after merge", q)
+	}
+}
+
+func TestReservoirBounded(t *testing.T) {
+	r := newReservoir(10)
+	for i := 0; i < 1000; i++ {
+		r.add(float64(i))
+	}
+	assert.Len(t, r.samples, 10)
+	assert.EqualValues(t, 1000, r.seen)
+}
+
+func TestSampledHistogramFlushResets(t *testing.T) {
+	h := newSampledHistogram()
+	for i := 1; i <= 100; i++ {
+		h.sample(float64(i))
+	}
+
+	gauges := h.flush()
+	assert.InDelta(t, 50, gauges.p50, 10)
+	assert.InDelta(t, 100, gauges.max, 5)
+	assert.Equal(t, int64(0), h.reservoir.seen)
+}
@@ -0,0 +1,278 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build linux_bpf
+
+package probe
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// anomalyShortWindow is the EWMA window used to approximate an event
+	// key's current rate.
+	anomalyShortWindow = 30 * time.Second
+	// anomalyLongWindow is the EWMA window used to approximate an event
+	// key's learned baseline rate.
+	anomalyLongWindow = time.Hour
+	// anomalyThreshold is how far above baseline (short/long) a key's rate
+	// has to deviate before it's considered anomalous.
+	anomalyThreshold = 5.0
+	// anomalyCooldown is how long a key that triggered the gate is refused
+	// new discarders for, counted from the moment it triggered. A key that is
+	// still anomalous once the cooldown expires triggers it again.
+	anomalyCooldown = 5 * time.Minute
+	// anomalySweepInterval bounds how often AnomalyGate scans its counter
+	// maps for stale entries to evict. Checked opportunistically on every
+	// AllowDiscard* call rather than on a dedicated ticker, so idle gates
+	// (no sensitive event types enabled) never pay for it.
+	anomalySweepInterval = time.Minute
+)
+
+// AnomalyMetricsSink receives one call every time the gate suppresses a
+// discarder, so the caller can bump
+// `datadog.security_agent.discarder.anomaly_suppressed{event_type,reason}`.
+// It defaults to a no-op so AnomalyGate is usable without a statsd client.
+var AnomalyMetricsSink func(eventType EventType, reason string) = func(EventType, string) {}
+
+// anomalyCounter tracks a short-window and long-window EWMA of event counts
+// for a single key, so a sudden short/long deviation can be detected
+// without retaining the full event history.
+type anomalyCounter struct {
+	short, long   float64
+	firstEvent    time.Time
+	lastEvent     time.Time
+	suppressUntil time.Time
+}
+
+// observe folds in one event and reports whether the key is currently
+// anomalous: its short-window rate is more than anomalyThreshold times its
+// long-window baseline rate. short and long are raw EWMA accumulators over
+// windows of very different sizes, so they have to be converted to
+// events/second before being compared; comparing the raw accumulators
+// directly always favors the much larger long-window value, and the
+// anomalyThreshold crossing could never be reached.
+//
+// A key isn't considered anomalous until it's been observed for at least
+// anomalyLongWindow: long starts at zero and needs that long to settle into
+// a meaningful baseline, so evaluating the ratio any earlier would read a
+// cold start (or a probe restart) as a rate deviation on perfectly steady
+// traffic.
+func (c *anomalyCounter) observe(now time.Time) bool {
+	if c.lastEvent.IsZero() {
+		c.firstEvent = now
+	} else {
+		elapsed := now.Sub(c.lastEvent)
+		c.short = ewma(c.short, elapsed, anomalyShortWindow)
+		c.long = ewma(c.long, elapsed, anomalyLongWindow)
+	}
+	c.lastEvent = now
+	c.short++
+	c.long++
+
+	warmedUp := now.Sub(c.firstEvent) >= anomalyLongWindow
+	shortRate := c.short / anomalyShortWindow.Seconds()
+	longRate := c.long / anomalyLongWindow.Seconds()
+	if warmedUp && !now.Before(c.suppressUntil) && longRate > 0 && shortRate > anomalyThreshold*longRate {
+		c.suppressUntil = now.Add(anomalyCooldown)
+	}
+	return now.Before(c.suppressUntil)
+}
+
+// ewma decays value by the fraction of window that elapsed since the last
+// observation, approximating a continuous exponential moving average from
+// irregularly spaced discarder calls.
+func ewma(value float64, elapsed, window time.Duration) float64 {
+	if elapsed <= 0 {
+		return value
+	}
+	decay := float64(elapsed) / float64(window)
+	if decay > 1 {
+		decay = 1
+	}
+	return value * (1 - decay)
+}
+
+type inodeAnomalyKey struct {
+	eventType EventType
+	mountID   uint32
+	inode     uint64
+}
+
+type pidAnomalyKey struct {
+	eventType EventType
+	pid       uint32
+}
+
+// ruleSensitivity tracks which event types a loaded rule has declared
+// anomaly_sensitive: true for. Event types outside this set are never
+// gated, since most discarders are perfectly safe to apply blindly.
+type ruleSensitivity struct {
+	mu    sync.RWMutex
+	types map[EventType]bool
+}
+
+func (s *ruleSensitivity) enable(eventType EventType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.types[eventType] = true
+}
+
+func (s *ruleSensitivity) isEnabled(eventType EventType) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.types[eventType]
+}
+
+// AnomalyGate wraps activeKFilters to automatically un-discard, or refuse to
+// discard, inodes/PIDs whose event rate suddenly deviates from a learned
+// baseline. This gives analysts visibility into a compromised process even
+// when a policy would normally silence it through discardInode/discardPID.
+//
+// Only event types opted in via EnableForEventType are gated; by default
+// sensitiveTypes is empty and every AllowDiscard* call is a pass-through.
+// Nothing in this tree parses an `anomaly_sensitive: true` rule field or sets
+// AnomalyMetricsSink, so the gate is inert unless something calls
+// EnableForEventType directly.
+type AnomalyGate struct {
+	probe *Probe
+
+	mu             sync.Mutex
+	inodeCounters  map[inodeAnomalyKey]*anomalyCounter
+	pidCounters    map[pidAnomalyKey]*anomalyCounter
+	sensitiveTypes *ruleSensitivity
+	lastSweep      time.Time
+}
+
+// NewAnomalyGate returns an AnomalyGate for probe with no event types opted
+// in yet.
+func NewAnomalyGate(probe *Probe) *AnomalyGate {
+	return &AnomalyGate{
+		probe:          probe,
+		inodeCounters:  make(map[inodeAnomalyKey]*anomalyCounter),
+		pidCounters:    make(map[pidAnomalyKey]*anomalyCounter),
+		sensitiveTypes: &ruleSensitivity{types: make(map[EventType]bool)},
+	}
+}
+
+// EnableForEventType opts eventType into anomaly detection. Nothing in this
+// tree calls it; see AnomalyGate.
+func (g *AnomalyGate) EnableForEventType(eventType EventType) {
+	g.sensitiveTypes.enable(eventType)
+}
+
+// sweep evicts counters that haven't observed an event in over
+// anomalyLongWindow, so inode/PID cardinality on a long-running host doesn't
+// grow the counter maps without bound. It's a no-op unless anomalySweepInterval
+// has elapsed since the last sweep. Callers must hold g.mu.
+func (g *AnomalyGate) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < anomalySweepInterval {
+		return
+	}
+	g.lastSweep = now
+
+	for key, c := range g.inodeCounters {
+		if now.Sub(c.lastEvent) > anomalyLongWindow {
+			delete(g.inodeCounters, key)
+		}
+	}
+	for key, c := range g.pidCounters {
+		if now.Sub(c.lastEvent) > anomalyLongWindow {
+			delete(g.pidCounters, key)
+		}
+	}
+}
+
+// AllowDiscardInode records one event for (eventType,mountID,inode) and
+// reports whether a new discardInode call should still be allowed to
+// proceed. When it refuses, it also proactively removes any active
+// discarder already in place for the key, the same way removeDiscarderInode
+// does, so the suppression takes effect immediately rather than waiting for
+// the existing discarder to expire.
+func (g *AnomalyGate) AllowDiscardInode(eventType EventType, mountID uint32, inode uint64) bool {
+	if !g.sensitiveTypes.isEnabled(eventType) {
+		return true
+	}
+
+	key := inodeAnomalyKey{eventType: eventType, mountID: mountID, inode: inode}
+	now := time.Now()
+
+	g.mu.Lock()
+	g.sweep(now)
+	c, ok := g.inodeCounters[key]
+	if !ok {
+		c = &anomalyCounter{}
+		g.inodeCounters[key] = c
+	}
+	anomalous := c.observe(now)
+	g.mu.Unlock()
+
+	if anomalous {
+		removeDiscarderInode(g.probe, mountID, inode)
+		AnomalyMetricsSink(eventType, "rate_deviation")
+		return false
+	}
+	return true
+}
+
+// AllowDiscardPID records one event for (eventType,pid) and reports whether
+// a new discardPID/discardPIDWithTimeout call should still be allowed to
+// proceed, removing any existing discarder for the key if it refuses.
+func (g *AnomalyGate) AllowDiscardPID(eventType EventType, pid uint32) bool {
+	if !g.sensitiveTypes.isEnabled(eventType) {
+		return true
+	}
+
+	key := pidAnomalyKey{eventType: eventType, pid: pid}
+	now := time.Now()
+
+	g.mu.Lock()
+	g.sweep(now)
+	c, ok := g.pidCounters[key]
+	if !ok {
+		c = &anomalyCounter{}
+		g.pidCounters[key] = c
+	}
+	anomalous := c.observe(now)
+	g.mu.Unlock()
+
+	if anomalous {
+		g.removeDiscarderPID(eventType, pid)
+		AnomalyMetricsSink(eventType, "rate_deviation")
+		return false
+	}
+	return true
+}
+
+// removeDiscarderPID deletes an active pid_discarders entry the same way
+// removeDiscarderInode does for inode_discarders.
+func (g *AnomalyGate) removeDiscarderPID(eventType EventType, pid uint32) {
+	key := pidDiscarder{
+		eventType: eventType,
+		pid:       pid,
+	}
+	table := g.probe.Map("pid_discarders")
+	table.Delete(&key)
+}
+
+var (
+	anomalyGatesMu sync.Mutex
+	anomalyGates   = make(map[*Probe]*AnomalyGate)
+)
+
+// anomalyGateFor returns probe's AnomalyGate, creating it on first use.
+func anomalyGateFor(probe *Probe) *AnomalyGate {
+	anomalyGatesMu.Lock()
+	defer anomalyGatesMu.Unlock()
+
+	g, ok := anomalyGates[probe]
+	if !ok {
+		g = NewAnomalyGate(probe)
+		anomalyGates[probe] = g
+	}
+	return g
+}
@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build linux_bpf
+
+package probe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAnomalyCounterObserveCrossesThresholdAndCoolsDown drives a counter
+// through a steady baseline, a sudden burst that should trip the gate, the
+// cooldown it enters once tripped, and recovery once the cooldown expires.
+func TestAnomalyCounterObserveCrossesThresholdAndCoolsDown(t *testing.T) {
+	c := &anomalyCounter{}
+	now := time.Now()
+
+	// Steady baseline traffic, long enough to settle the long-window EWMA.
+	for i := 0; i < 3*3600; i++ {
+		now = now.Add(time.Second)
+		assert.False(t, c.observe(now), "baseline traffic must never be anomalous")
+	}
+
+	// A sudden 50x burst should eventually cross anomalyThreshold.
+	var anomalous bool
+	for i := 0; i < 500 && !anomalous; i++ {
+		now = now.Add(20 * time.Millisecond)
+		anomalous = c.observe(now)
+	}
+	assert.True(t, anomalous, "sustained burst should trip the anomaly gate")
+
+	// Still within the cooldown, the counter should keep reporting anomalous.
+	now = now.Add(anomalyCooldown / 2)
+	assert.True(t, c.observe(now), "counter should still be suppressed mid-cooldown")
+
+	// Once the cooldown has fully elapsed, it should recover.
+	now = now.Add(anomalyCooldown)
+	assert.False(t, c.observe(now), "counter should recover once cooldown expires")
+}
+
+// TestAnomalyGateSweepEvictsStaleCounters confirms sweep evicts a counter
+// that hasn't observed an event in over anomalyLongWindow, while leaving a
+// recently observed one alone.
+func TestAnomalyGateSweepEvictsStaleCounters(t *testing.T) {
+	g := NewAnomalyGate(nil)
+	now := time.Now()
+
+	staleInode := inodeAnomalyKey{eventType: EventType(1), mountID: 1, inode: 100}
+	freshInode := inodeAnomalyKey{eventType: EventType(1), mountID: 1, inode: 200}
+	g.inodeCounters[staleInode] = &anomalyCounter{lastEvent: now.Add(-anomalyLongWindow - time.Second)}
+	g.inodeCounters[freshInode] = &anomalyCounter{lastEvent: now}
+
+	stalePID := pidAnomalyKey{eventType: EventType(1), pid: 1}
+	freshPID := pidAnomalyKey{eventType: EventType(1), pid: 2}
+	g.pidCounters[stalePID] = &anomalyCounter{lastEvent: now.Add(-anomalyLongWindow - time.Second)}
+	g.pidCounters[freshPID] = &anomalyCounter{lastEvent: now}
+
+	g.mu.Lock()
+	g.sweep(now)
+	g.mu.Unlock()
+
+	_, staleInodeLeft := g.inodeCounters[staleInode]
+	_, freshInodeLeft := g.inodeCounters[freshInode]
+	assert.False(t, staleInodeLeft, "stale inode counter should be evicted")
+	assert.True(t, freshInodeLeft, "fresh inode counter should survive the sweep")
+
+	_, stalePIDLeft := g.pidCounters[stalePID]
+	_, freshPIDLeft := g.pidCounters[freshPID]
+	assert.False(t, stalePIDLeft, "stale pid counter should be evicted")
+	assert.True(t, freshPIDLeft, "fresh pid counter should survive the sweep")
+}
@@ -29,6 +29,10 @@ type pidDiscarderParameters struct {
 }
 
 func discardPID(probe *Probe, eventType EventType, pid uint32) (activeDiscarder, error) {
+	if !anomalyGateFor(probe).AllowDiscardPID(eventType, pid) {
+		return nil, nil
+	}
+
 	key := pidDiscarder{
 		eventType: eventType,
 		pid:       pid,
@@ -43,6 +47,10 @@ func discardPID(probe *Probe, eventType EventType, pid uint32) (activeDiscarder,
 }
 
 func discardPIDWithTimeout(probe *Probe, eventType EventType, pid uint32, timeout time.Duration) (activeDiscarder, error) {
+	if !anomalyGateFor(probe).AllowDiscardPID(eventType, pid) {
+		return nil, nil
+	}
+
 	key := pidDiscarder{
 		eventType: eventType,
 		pid:       pid,
@@ -80,6 +88,10 @@ func removeDiscarderInode(probe *Probe, mountID uint32, inode uint64) {
 }
 
 func discardInode(probe *Probe, eventType EventType, mountID uint32, inode uint64) (activeDiscarder, error) {
+	if !anomalyGateFor(probe).AllowDiscardInode(eventType, mountID, inode) {
+		return nil, nil
+	}
+
 	key := inodeDiscarder{
 		eventType: eventType,
 		pathKey: PathKey{
@@ -43,6 +43,10 @@ type PriorityEngine struct {
 	rateByService *RateByService
 	catalog       *serviceKeyCatalog
 	exit          chan struct{}
+
+	// signatureTPS converges operator-pinned signatures to their own
+	// configured target throughput, on top of the Sampler's global targetTPS.
+	signatureTPS *signatureTPSController
 }
 
 // NewPriorityEngine returns an initialized Sampler
@@ -52,8 +56,10 @@ func NewPriorityEngine(extraRate float64, targetTPS float64, rateByService *Rate
 		rateByService: rateByService,
 		catalog:       newServiceLookup(),
 		exit:          make(chan struct{}),
+		signatureTPS:  newSignatureTPSController(),
 	}
 	s.Sampler.setRateThresholdTo1(prioritySamplingRateThresholdTo1)
+	s.signatureTPS.loadSignatureTargetTPSConfig()
 
 	return s
 }
@@ -75,6 +81,7 @@ func (s *PriorityEngine) Run() {
 		for {
 			select {
 			case <-t.C:
+				s.signatureTPS.tick(syncPeriod)
 				s.rateByService.SetAll(s.ratesByService())
 			case <-s.exit:
 				wg.Done()
@@ -116,19 +123,29 @@ func (s *PriorityEngine) Sample(trace pb.Trace, root *pb.Span, env string) bool
 		return sampled
 	}
 
-	signature := s.catalog.register(ServiceSignature{root.Service, env})
+	svcSignature := ServiceSignature{root.Service, env}
+	signature := s.catalog.register(svcSignature)
 
 	// Update sampler state by counting this trace
 	s.Sampler.Backend.CountSignature(signature)
+	s.signatureTPS.observe(svcSignature)
 
 	if sampled {
-		s.applyRate(sampled, root, signature)
+		s.applyRate(sampled, root, signature, svcSignature)
 		s.Sampler.Backend.CountSample()
 	}
 	return sampled
 }
 
-func (s *PriorityEngine) applyRate(sampled bool, root *pb.Span, signature Signature) {
+// SetSignatureTargetTPS pins svcSignature to a guaranteed target throughput
+// of tps traces per second, converged to over successive syncPeriod ticks
+// independently of the engine's global targetTPS. Passing a tps of 0 or less
+// unpins svcSignature, returning it to the engine's default behavior.
+func (s *PriorityEngine) SetSignatureTargetTPS(svcSignature ServiceSignature, tps float64) {
+	s.signatureTPS.setTarget(svcSignature, tps)
+}
+
+func (s *PriorityEngine) applyRate(sampled bool, root *pb.Span, signature Signature, svcSignature ServiceSignature) {
 	if root.ParentID != 0 {
 		return
 	}
@@ -146,7 +163,10 @@ func (s *PriorityEngine) applyRate(sampled bool, root *pb.Span, signature Signat
 	// dd-trace-go used to set the rate in deprecatedRateKey
 	if _, ok := getMetric(root, deprecatedRateKey); !ok {
 		// if it's not set add next rate
-		rate := s.Sampler.GetSignatureSampleRate(signature)
+		rate, ok := s.signatureTPS.rate(svcSignature)
+		if !ok {
+			rate = s.Sampler.GetSignatureSampleRate(signature)
+		}
 		if rate > prioritySamplingRateThresholdTo1 {
 			rate = 1
 		}
@@ -154,10 +174,29 @@ func (s *PriorityEngine) applyRate(sampled bool, root *pb.Span, signature Signat
 	}
 }
 
+// PriorityEngineState wraps the underlying Sampler's state with the
+// per-signature target-TPS rates this engine additionally maintains, so
+// GetState keeps reporting everything SetSignatureTargetTPS affects.
+type PriorityEngineState struct {
+	State                interface{}        `json:"state"`
+	SignatureTargetRates map[string]float64 `json:"signatureTargetRates"`
+}
+
 // GetState collects and return internal statistics and coefficients for indication purposes
 // It returns an interface{}, as other samplers might return other informations.
 func (s *PriorityEngine) GetState() interface{} {
-	return s.Sampler.GetState()
+	return PriorityEngineState{
+		State:                s.Sampler.GetState(),
+		SignatureTargetRates: s.GetSignatureTargetRates(),
+	}
+}
+
+// GetSignatureTargetRates returns the rate every signature pinned via
+// SetSignatureTargetTPS has currently converged to, keyed by "<service>,<env>",
+// so operators can confirm a high-value service is getting its guaranteed
+// sample volume.
+func (s *PriorityEngine) GetSignatureTargetRates() map[string]float64 {
+	return s.signatureTPS.effectiveRates()
 }
 
 // ratesByService returns all rates by service, this information is useful for
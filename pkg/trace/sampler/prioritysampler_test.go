@@ -0,0 +1,33 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package sampler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityEngineStateMarshalsToJSON(t *testing.T) {
+	state := PriorityEngineState{
+		State:                struct{}{},
+		SignatureTargetRates: map[string]float64{"checkout,prod": maxSignatureRate},
+	}
+
+	raw, err := json.Marshal(state)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"state":{},"signatureTargetRates":{"checkout,prod":1}}`, string(raw))
+}
+
+func TestPriorityEngineStateMarshalsToJSONWhenEmpty(t *testing.T) {
+	state := PriorityEngineState{SignatureTargetRates: map[string]float64{}}
+
+	raw, err := json.Marshal(state)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"state":null,"signatureTargetRates":{}}`, string(raw))
+}
@@ -0,0 +1,193 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package sampler
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// signatureTargetTPSConfigKey is the datadog.yaml key holding the
+// operator-pinned per-signature targets loaded by loadSignatureTargetTPSConfig,
+// as a map of "<service>,<env>" to target TPS.
+const signatureTargetTPSConfigKey = "apm_config.sampling.per_signature"
+
+const (
+	// minSignatureRate is the lowest sampling rate the per-signature
+	// controller will converge a signature to, regardless of how far above
+	// its target the observed throughput is.
+	minSignatureRate = 0.01
+	// maxSignatureRate is the highest sampling rate the controller can set;
+	// it can never sample more traffic than it receives.
+	maxSignatureRate = 1.0
+	// signatureRateHysteresis bounds how much a signature's rate is allowed
+	// to move in a single tick, to avoid oscillation around its target.
+	signatureRateHysteresis = 0.25
+)
+
+// signatureTarget holds the operator-pinned target throughput for one
+// service+env signature, along with the rate the controller last converged
+// it to and the traffic observed since the previous tick.
+type signatureTarget struct {
+	targetTPS float64
+	rate      float64
+	count     uint64
+}
+
+// signatureTPSController converges each service+env signature independently
+// to its own configured target throughput (apm_config.sampling.per_signature),
+// instead of relying on the single global targetTPS applied by Sampler. It is
+// driven once per syncPeriod tick from PriorityEngine.Run, and consulted on
+// every Sample call for signatures it has been pinned to.
+type signatureTPSController struct {
+	mu      sync.Mutex
+	targets map[ServiceSignature]*signatureTarget
+}
+
+func newSignatureTPSController() *signatureTPSController {
+	return &signatureTPSController{
+		targets: make(map[ServiceSignature]*signatureTarget),
+	}
+}
+
+// setTarget pins sig to the given target throughput, so operators can
+// guarantee sample volume for high-value services while noisy signatures are
+// left to the engine's default behavior. A tps of 0 or less unpins sig.
+func (c *signatureTPSController) setTarget(sig ServiceSignature, tps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tps <= 0 {
+		delete(c.targets, sig)
+		return
+	}
+	t, ok := c.targets[sig]
+	if !ok {
+		t = &signatureTarget{rate: maxSignatureRate}
+		c.targets[sig] = t
+	}
+	t.targetTPS = tps
+}
+
+// observe counts one incoming trace for sig, to be folded into the next
+// tick's observed-TPS computation. It is a no-op for signatures that aren't
+// pinned, since those are left to the engine's default rate.
+func (c *signatureTPSController) observe(sig ServiceSignature) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.targets[sig]; ok {
+		t.count++
+	}
+}
+
+// rate returns the rate currently in effect for sig and whether sig is
+// pinned to a target throughput at all.
+func (c *signatureTPSController) rate(sig ServiceSignature) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.targets[sig]
+	if !ok {
+		return 0, false
+	}
+	return t.rate, true
+}
+
+// effectiveRates returns the rate every currently pinned signature has
+// converged to, keyed by the same "<service>,<env>" form used in
+// datadog.yaml, for callers like GetState that JSON-encode the result for
+// the trace-agent's debug/status surface (ServiceSignature itself isn't a
+// valid JSON map key).
+func (c *signatureTPSController) effectiveRates() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rates := make(map[string]float64, len(c.targets))
+	for sig, t := range c.targets {
+		rates[formatServiceSignatureKey(sig)] = t.rate
+	}
+	return rates
+}
+
+// tick recomputes every pinned signature's rate from the traffic observed
+// over the last period: new_rate = clamp(old_rate * target/observed, min, max),
+// with hysteresis limiting how far the rate can move in one tick. The snap to
+// 1 is checked against this raw, pre-hysteresis rate, since checking it after
+// hysteresis has already clamped the step would re-trigger on every
+// subsequent tick and undo the decrease before it ever reaches target.
+func (c *signatureTPSController) tick(period time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seconds := period.Seconds()
+	for _, t := range c.targets {
+		observed := float64(t.count) / seconds
+		t.count = 0
+
+		next := t.rate
+		if observed > 0 {
+			next = t.rate * (t.targetTPS / observed)
+		}
+
+		if next > prioritySamplingRateThresholdTo1 {
+			t.rate = maxSignatureRate
+			continue
+		}
+
+		if delta := next - t.rate; delta > signatureRateHysteresis {
+			next = t.rate + signatureRateHysteresis
+		} else if delta < -signatureRateHysteresis {
+			next = t.rate - signatureRateHysteresis
+		}
+
+		t.rate = clampSignatureRate(next)
+	}
+}
+
+func clampSignatureRate(rate float64) float64 {
+	if rate < minSignatureRate {
+		return minSignatureRate
+	}
+	if rate > maxSignatureRate {
+		return maxSignatureRate
+	}
+	return rate
+}
+
+// loadSignatureTargetTPSConfig reads signatureTargetTPSConfigKey from
+// datadog.yaml and pins every "<service>,<env>" entry found there to its
+// configured target throughput, so operators can guarantee sample volume for
+// a service without a Go caller having to invoke setTarget directly.
+func (c *signatureTPSController) loadSignatureTargetTPSConfig() {
+	for key, tps := range config.Datadog.GetStringMapFloat64(signatureTargetTPSConfigKey) {
+		sig, ok := parseServiceSignatureKey(key)
+		if !ok {
+			continue
+		}
+		c.setTarget(sig, tps)
+	}
+}
+
+// parseServiceSignatureKey parses a "<service>,<env>" datadog.yaml map key
+// into a ServiceSignature, reporting false if it isn't in that form.
+func parseServiceSignatureKey(key string) (ServiceSignature, bool) {
+	parts := strings.SplitN(key, ",", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ServiceSignature{}, false
+	}
+	return ServiceSignature{parts[0], parts[1]}, true
+}
+
+// formatServiceSignatureKey renders sig in the same "<service>,<env>" form
+// parseServiceSignatureKey accepts, so callers can round-trip a signature
+// through datadog.yaml or a JSON-encoded map key.
+func formatServiceSignatureKey(sig ServiceSignature) string {
+	return sig.Name + "," + sig.Env
+}
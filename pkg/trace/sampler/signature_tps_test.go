@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureTPSControllerConvergesToTarget(t *testing.T) {
+	c := newSignatureTPSController()
+	sig := ServiceSignature{"checkout", "prod"}
+	c.setTarget(sig, 10)
+
+	for tick := 0; tick < 50; tick++ {
+		rate, ok := c.rate(sig)
+		assert.True(t, ok)
+		observed := int(100 * rate) // pretend 100 tps arrives, rate% of it gets through
+		for i := 0; i < observed; i++ {
+			c.observe(sig)
+		}
+		c.tick(time.Second)
+	}
+
+	rate, ok := c.rate(sig)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.1, rate, 0.03)
+}
+
+func TestSignatureTPSControllerUnpin(t *testing.T) {
+	c := newSignatureTPSController()
+	sig := ServiceSignature{"checkout", "prod"}
+	c.setTarget(sig, 10)
+	c.setTarget(sig, 0)
+
+	_, ok := c.rate(sig)
+	assert.False(t, ok)
+}
+
+func TestSignatureTPSControllerEffectiveRates(t *testing.T) {
+	c := newSignatureTPSController()
+	sig := ServiceSignature{"checkout", "prod"}
+	c.setTarget(sig, 10)
+
+	rates := c.effectiveRates()
+	assert.Len(t, rates, 1)
+	assert.Equal(t, maxSignatureRate, rates["checkout,prod"])
+}
+
+func TestFormatServiceSignatureKey(t *testing.T) {
+	assert.Equal(t, "checkout,prod", formatServiceSignatureKey(ServiceSignature{"checkout", "prod"}))
+}
+
+func TestParseServiceSignatureKey(t *testing.T) {
+	sig, ok := parseServiceSignatureKey("checkout,prod")
+	assert.True(t, ok)
+	assert.Equal(t, ServiceSignature{"checkout", "prod"}, sig)
+
+	_, ok = parseServiceSignatureKey("checkout")
+	assert.False(t, ok)
+
+	_, ok = parseServiceSignatureKey(",prod")
+	assert.False(t, ok)
+
+	_, ok = parseServiceSignatureKey("checkout,")
+	assert.False(t, ok)
+}
@@ -0,0 +1,208 @@
+// +build linux
+
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/metadata/host"
+	"github.com/mholt/archiver/v3"
+)
+
+// mirrorHTTPTimeout bounds every request issued by the BTF and kernel-header
+// mirror downloaders, so a slow or wedged mirror can't hang agent/system-probe
+// startup indefinitely.
+const mirrorHTTPTimeout = 30 * time.Second
+
+// mirrorHTTPClient is shared by the BTF and kernel-header downloaders.
+var mirrorHTTPClient = &http.Client{Timeout: mirrorHTTPTimeout}
+
+// BTFSource identifies where a resolved BTF blob came from.
+type BTFSource string
+
+const (
+	// BTFSourceSysfs means the running kernel already exposes its own BTF.
+	BTFSourceSysfs BTFSource = "sysfs"
+	// BTFSourceCache means a previously downloaded/extracted BTF blob was reused.
+	BTFSourceCache BTFSource = "cache"
+	// BTFSourceDownload means the blob was just pulled from BTFMirrorURL.
+	BTFSourceDownload BTFSource = "download"
+)
+
+const vmlinuxBTFPath = "/sys/kernel/btf/vmlinux"
+const btfManifestName = "manifest.json"
+
+// BTFCacheDirFmt is the local cache dir FindBTF probes for a vmlinux BTF
+// blob, formatted with the host's uname -r. Downloaded blobs are not written
+// here; they are extracted under os.TempDir(). Configurable so callers running
+// outside the default datadog-agent filesystem layout (tests, alternate
+// install prefixes) can point it elsewhere.
+var BTFCacheDirFmt = "/var/lib/datadog-agent/btf/%s"
+
+// PreferBTF controls whether a CO-RE capable caller should attempt
+// BTF-first resolution via FindBTF before falling back to FindHeaderDirs.
+var PreferBTF bool
+
+// BTFMirrorURL is the base URL of a mirror serving pre-generated per-kernel
+// BTF blobs plus a manifest.json mapping artifact name to sha256. Empty
+// disables the downloader path.
+var BTFMirrorURL string
+
+// btfManifest is the manifest.json format served alongside BTF blobs: it maps
+// an artifact file name to its expected sha256 hex digest.
+type btfManifest struct {
+	Artifacts map[string]string `json:"artifacts"`
+}
+
+// FindBTF resolves a usable BTF blob for the running kernel, probing in
+// order: the kernel's own /sys/kernel/btf/vmlinux, a local on-disk cache, and
+// finally a download from BTFMirrorURL. The returned path always points at a
+// vmlinux BTF blob on disk; source indicates which of those it came from.
+func FindBTF() (string, BTFSource, error) {
+	if fileExists(vmlinuxBTFPath) {
+		return vmlinuxBTFPath, BTFSourceSysfs, nil
+	}
+
+	hv, err := HostVersion()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to determine host kernel version: %w", err)
+	}
+
+	hi := host.GetStatusInformation()
+	if hi.KernelVersion == "" {
+		return "", "", fmt.Errorf("unable to get host metadata")
+	}
+
+	cacheDir := fmt.Sprintf(BTFCacheDirFmt, hi.KernelVersion)
+	cachePath := filepath.Join(cacheDir, "vmlinux")
+	if fileExists(cachePath) {
+		return cachePath, BTFSourceCache, nil
+	}
+
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("btf-%s", hv))
+	tmpPath := filepath.Join(tmpDir, "vmlinux")
+	if fileExists(tmpPath) {
+		return tmpPath, BTFSourceCache, nil
+	}
+
+	if BTFMirrorURL == "" {
+		return "", "", fmt.Errorf("no local BTF available and no mirror configured")
+	}
+
+	path, err := downloadBTF(tmpDir, hi.Platform, hi.PlatformVersion, hi.KernelVersion)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to download BTF: %w", err)
+	}
+	return path, BTFSourceDownload, nil
+}
+
+// downloadBTF fetches the manifest from BTFMirrorURL, locates the artifact
+// matching platform/platformVersion/unameR, downloads it, verifies its
+// sha256 against the manifest and extracts it into destDir.
+func downloadBTF(destDir, platform, platformVersion, unameR string) (string, error) {
+	manifest, err := fetchBTFManifest()
+	if err != nil {
+		return "", err
+	}
+
+	artifact := fmt.Sprintf("%s-%s-%s.btf.tar.xz", unameR, platform, platformVersion)
+	sum, ok := manifest.Artifacts[artifact]
+	if !ok {
+		return "", fmt.Errorf("no BTF artifact published for %s", artifact)
+	}
+
+	archivePath := filepath.Join(os.TempDir(), artifact)
+	if err := downloadToFile(BTFMirrorURL+"/"+artifact, archivePath); err != nil {
+		return "", err
+	}
+	defer os.Remove(archivePath)
+
+	if err := verifySHA256(archivePath, sum); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create BTF cache dir: %w", err)
+	}
+	txz := archiver.NewTarXz()
+	if err := txz.Unarchive(archivePath, destDir); err != nil {
+		return "", fmt.Errorf("unable to extract BTF archive: %w", err)
+	}
+
+	path := filepath.Join(destDir, "vmlinux")
+	if !fileExists(path) {
+		return "", fmt.Errorf("extracted BTF archive did not contain vmlinux")
+	}
+	return path, nil
+}
+
+func fetchBTFManifest() (*btfManifest, error) {
+	resp, err := mirrorHTTPClient.Get(BTFMirrorURL + "/" + btfManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch BTF manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch BTF manifest: unexpected status %s", resp.Status)
+	}
+
+	var manifest btfManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse BTF manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func downloadToFile(url, dest string) error {
+	resp, err := mirrorHTTPClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: unexpected status %s", url, resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", dest, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("unable to write %s: %w", dest, err)
+	}
+	return nil
+}
+
+func verifySHA256(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("unable to hash %s: %w", path, err)
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", path, expected, actual)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,122 @@
+// +build linux
+
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archiver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildBTFArchive writes a single vmlinux file under a temp dir, tars+xzs it,
+// and returns the archive path plus its sha256.
+func buildBTFArchive(t *testing.T, contents []byte) (archivePath, sha256Hex string) {
+	t.Helper()
+
+	srcDir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, "vmlinux"), contents, 0644))
+
+	archivePath = filepath.Join(t.TempDir(), "btf.tar.xz")
+	txz := archiver.NewTarXz()
+	require.NoError(t, txz.Archive([]string{filepath.Join(srcDir, "vmlinux")}, archivePath))
+
+	f, err := os.Open(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	require.NoError(t, err)
+
+	return archivePath, hex.EncodeToString(h.Sum(nil))
+}
+
+func TestDownloadBTFFetchesVerifiesAndExtracts(t *testing.T) {
+	const platform, platformVersion, unameR = "ubuntu", "20.04", "5.4.0-generic"
+	contents := []byte("fake vmlinux BTF blob")
+	archivePath, sum := buildBTFArchive(t, contents)
+	archiveBytes, err := ioutil.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	artifact := fmt.Sprintf("%s-%s-%s.btf.tar.xz", unameR, platform, platformVersion)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+btfManifestName, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(btfManifest{
+			Artifacts: map[string]string{artifact: sum},
+		})
+	})
+	mux.HandleFunc("/"+artifact, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archiveBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	origURL := BTFMirrorURL
+	BTFMirrorURL = server.URL
+	defer func() { BTFMirrorURL = origURL }()
+
+	destDir := filepath.Join(t.TempDir(), "btf-dest")
+	path, err := downloadBTF(destDir, platform, platformVersion, unameR)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(destDir, "vmlinux"), path)
+
+	got, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+}
+
+func TestDownloadBTFRejectsChecksumMismatch(t *testing.T) {
+	const platform, platformVersion, unameR = "ubuntu", "20.04", "5.4.0-generic"
+	archivePath, _ := buildBTFArchive(t, []byte("fake vmlinux BTF blob"))
+	archiveBytes, err := ioutil.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	artifact := fmt.Sprintf("%s-%s-%s.btf.tar.xz", unameR, platform, platformVersion)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+btfManifestName, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(btfManifest{
+			Artifacts: map[string]string{artifact: "0000000000000000000000000000000000000000000000000000000000000000"},
+		})
+	})
+	mux.HandleFunc("/"+artifact, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archiveBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	origURL := BTFMirrorURL
+	BTFMirrorURL = server.URL
+	defer func() { BTFMirrorURL = origURL }()
+
+	destDir := filepath.Join(t.TempDir(), "btf-dest")
+	_, err = downloadBTF(destDir, platform, platformVersion, unameR)
+	assert.Error(t, err)
+}
+
+func TestDownloadBTFNoArtifactPublished(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+btfManifestName, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(btfManifest{Artifacts: map[string]string{}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	origURL := BTFMirrorURL
+	BTFMirrorURL = server.URL
+	defer func() { BTFMirrorURL = origURL }()
+
+	_, err := downloadBTF(t.TempDir(), "ubuntu", "20.04", "5.4.0-generic")
+	assert.Error(t, err)
+}
@@ -5,13 +5,17 @@ package kernel
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
+	"syscall"
 
 	"github.com/DataDog/datadog-agent/pkg/metadata/host"
 	"github.com/mholt/archiver/v3"
@@ -19,7 +23,31 @@ import (
 
 const sysfsHeadersPath = "/sys/kernel/kheaders.tar.xz"
 const kernelModulesPath = "/lib/modules/%s/build"
+const headersCacheDirFmt = "/var/lib/datadog-agent/system-probe/linux-headers/%s"
+const headersManifestName = "manifest.json"
 
+// HeadersMirrorURL is the base URL of an HTTP(S) mirror serving pre-built
+// kernel header tarballs plus a manifest.json mapping artifact name to
+// sha256, configured via system_probe_config.kernel_headers_download_url.
+// Empty disables the downloader path.
+var HeadersMirrorURL string
+
+// HeadersMirrorToken is an optional bearer token sent with every request to
+// HeadersMirrorURL, for mirrors that require authentication.
+var HeadersMirrorToken string
+
+// headersManifest is the manifest.json format served alongside header
+// tarballs: it maps an artifact file name to its expected sha256 hex digest.
+type headersManifest struct {
+	Artifacts map[string]string `json:"artifacts"`
+}
+
+// FindHeaderDirs resolves a set of directories containing kernel headers
+// usable with a compiler's -I flag. CO-RE capable callers that can work off a
+// single BTF blob file instead should check PreferBTF and call FindBTF
+// themselves before falling back to FindHeaderDirs; a BTF blob is not a
+// header directory and mixing the two into one return type would hand
+// unsuspecting -I callers a file path instead of a directory.
 func FindHeaderDirs() ([]string, error) {
 	hv, err := HostVersion()
 	if err != nil {
@@ -32,10 +60,17 @@ func FindHeaderDirs() ([]string, error) {
 	}
 
 	if os.IsNotExist(err) {
-		// as last resort, look for sysfs headers
+		// next, look for sysfs headers
 		if dir, syserr := getSysfsHeaderDirs(hv); syserr == nil {
 			return dir, nil
 		}
+
+		// as a last resort, download a matching tarball from a configured mirror
+		if HeadersMirrorURL != "" {
+			if dir, dlerr := getDownloadedHeaderDirs(hv); dlerr == nil {
+				return dir, nil
+			}
+		}
 	}
 	return nil, err
 }
@@ -154,4 +189,192 @@ func unloadKHeadersModule() error {
 		return fmt.Errorf("unable to unload kheaders module: %s", stderr.String())
 	}
 	return nil
+}
+
+// getDownloadedHeaderDirs resolves v by downloading a matching kernel
+// headers tarball from HeadersMirrorURL into a per-version cache dir. A file
+// lock around the cache dir coordinates concurrent agent starts on the same
+// host so only one of them performs the download; the rest block until the
+// winner finishes and then reuse its cache.
+func getDownloadedHeaderDirs(v Version) ([]string, error) {
+	hi := host.GetStatusInformation()
+	if hi.KernelVersion == "" {
+		return nil, fmt.Errorf("unable to get host metadata")
+	}
+
+	cacheDir := fmt.Sprintf(headersCacheDirFmt, hi.KernelVersion)
+	return downloadHeaderDirsInto(cacheDir, v, hi.Platform, hi.KernelVersion)
+}
+
+// downloadHeaderDirsInto does the actual download/verify/extract/validate
+// work for getDownloadedHeaderDirs, taking the host identifiers as
+// parameters rather than calling host.GetStatusInformation itself so it can
+// be exercised directly against a test mirror.
+func downloadHeaderDirsInto(cacheDir string, v Version, platform, kernelVersion string) ([]string, error) {
+	unlock, err := lockHeadersCacheDir(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire kernel headers download lock: %w", err)
+	}
+	defer unlock()
+
+	if hv, err := getHeaderVersion(cacheDir); err == nil && hv == v {
+		// another agent start already populated the cache while we were
+		// waiting for the lock
+		return []string{cacheDir}, nil
+	}
+
+	manifest, err := fetchHeadersManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, sum, err := selectHeadersArtifact(manifest, platform, kernelVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	archivePath := filepath.Join(os.TempDir(), artifact)
+	if err := downloadHeadersArtifact(artifact, archivePath); err != nil {
+		return nil, err
+	}
+	defer os.Remove(archivePath)
+
+	if err := verifySHA256(archivePath, sum); err != nil {
+		return nil, err
+	}
+
+	// extract into a scratch dir first and rename into place, so a reader
+	// that wins the lock race never observes a partially extracted cacheDir
+	tmpDir := cacheDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Dir(cacheDir), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create kernel headers cache dir: %w", err)
+	}
+	txz := archiver.NewTarXz()
+	if err := txz.Unarchive(archivePath, tmpDir); err != nil {
+		return nil, fmt.Errorf("unable to extract kernel headers archive: %w", err)
+	}
+	os.RemoveAll(cacheDir)
+	if err := os.Rename(tmpDir, cacheDir); err != nil {
+		return nil, fmt.Errorf("unable to install kernel headers cache dir: %w", err)
+	}
+
+	hv, err := getHeaderVersion(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify downloaded headers version: %w", err)
+	}
+	if hv != v {
+		return nil, fmt.Errorf("downloaded header version %s does not match expected host version %s", hv, v)
+	}
+	return []string{cacheDir}, nil
+}
+
+// lockHeadersCacheDir takes an exclusive file lock on a sibling of cacheDir,
+// blocking until it is acquired, and returns a function that releases it.
+func lockHeadersCacheDir(cacheDir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(cacheDir), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(cacheDir+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock %s: %w", f.Name(), err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// candidateHeaderArtifacts lists the tarball names that might carry headers
+// for unameR on platform, the generic linux-headers-<unameR>-<arch>.tar.xz
+// first, then any distro-specific fallback, so distros that don't publish the
+// generic name can still be resolved.
+func candidateHeaderArtifacts(platform, unameR string) []string {
+	arch := runtime.GOARCH
+	generic := fmt.Sprintf("linux-headers-%s-%s.tar.xz", unameR, arch)
+
+	switch platform {
+	case "debian", "ubuntu":
+		return []string{generic, fmt.Sprintf("linux-headers-%s-%s-%s.tar.xz", platform, unameR, arch)}
+	case "centos", "rhel", "fedora":
+		return []string{generic, fmt.Sprintf("kernel-devel-%s-%s.tar.xz", unameR, arch)}
+	case "amazon":
+		return []string{generic, fmt.Sprintf("kernel-devel-%s.amzn2-%s.tar.xz", unameR, arch)}
+	default:
+		return []string{generic}
+	}
+}
+
+// selectHeadersArtifact picks the first of candidateHeaderArtifacts that the
+// manifest actually publishes, along with its expected sha256.
+func selectHeadersArtifact(manifest *headersManifest, platform, unameR string) (string, string, error) {
+	for _, candidate := range candidateHeaderArtifacts(platform, unameR) {
+		if sum, ok := manifest.Artifacts[candidate]; ok {
+			return candidate, sum, nil
+		}
+	}
+	return "", "", fmt.Errorf("no kernel headers artifact published for %s on %s", unameR, platform)
+}
+
+func fetchHeadersManifest() (*headersManifest, error) {
+	resp, err := headersMirrorRequest(headersMirrorURL(headersManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch kernel headers manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch kernel headers manifest: unexpected status %s", resp.Status)
+	}
+
+	var manifest headersManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse kernel headers manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func downloadHeadersArtifact(artifact, dest string) error {
+	resp, err := headersMirrorRequest(headersMirrorURL(artifact))
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", artifact, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: unexpected status %s", artifact, resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", dest, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("unable to write %s: %w", dest, err)
+	}
+	return nil
+}
+
+func headersMirrorURL(artifact string) string {
+	return HeadersMirrorURL + "/" + artifact
+}
+
+// headersMirrorRequest issues a GET against url, attaching HeadersMirrorToken
+// as a bearer token when one is configured.
+func headersMirrorRequest(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if HeadersMirrorToken != "" {
+		req.Header.Set("Authorization", "Bearer "+HeadersMirrorToken)
+	}
+	return mirrorHTTPClient.Do(req)
 }
\ No newline at end of file
@@ -0,0 +1,138 @@
+// +build linux
+
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mholt/archiver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildHeadersTarball writes a kernel-headers tree under a temp dir
+// containing a version.h reporting versionCode, tars+xzs it, and returns the
+// archive path plus its sha256.
+func buildHeadersTarball(t *testing.T, versionCode int) (archivePath, sha256Hex string) {
+	t.Helper()
+
+	srcRoot := t.TempDir()
+	versionDir := filepath.Join(srcRoot, "include", "generated", "uapi", "linux")
+	require.NoError(t, os.MkdirAll(versionDir, 0755))
+
+	versionH := []byte(fmt.Sprintf("#define LINUX_VERSION_CODE %d\n", versionCode))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(versionDir, "version.h"), versionH, 0644))
+
+	archivePath = filepath.Join(t.TempDir(), "linux-headers.tar.xz")
+	txz := archiver.NewTarXz()
+	require.NoError(t, txz.Archive([]string{filepath.Join(srcRoot, "include")}, archivePath))
+
+	f, err := os.Open(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	require.NoError(t, err)
+
+	return archivePath, hex.EncodeToString(h.Sum(nil))
+}
+
+func TestDownloadHeaderDirsIntoDownloadsVerifiesAndExtracts(t *testing.T) {
+	const versionCode = 328192 // 5.2.0
+	const unameR = "5.2.0-generic"
+	archivePath, sum := buildHeadersTarball(t, versionCode)
+	archiveBytes, err := ioutil.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	artifact := fmt.Sprintf("linux-headers-%s-%s.tar.xz", unameR, runtime.GOARCH)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(headersManifest{
+			Artifacts: map[string]string{artifact: sum},
+		})
+	})
+	mux.HandleFunc("/"+artifact, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archiveBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	origURL := HeadersMirrorURL
+	HeadersMirrorURL = server.URL
+	defer func() { HeadersMirrorURL = origURL }()
+
+	cacheDir := filepath.Join(t.TempDir(), "linux-headers-5.2.0-generic")
+	dirs, err := downloadHeaderDirsInto(cacheDir, Version(versionCode), "", unameR)
+	require.NoError(t, err)
+	assert.Equal(t, []string{cacheDir}, dirs)
+
+	hv, err := getHeaderVersion(cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, Version(versionCode), hv)
+}
+
+func TestDownloadHeaderDirsIntoRejectsVersionMismatch(t *testing.T) {
+	archivePath, sum := buildHeadersTarball(t, 328960)
+	archiveBytes, err := ioutil.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	const unameR = "5.2.0-generic"
+	artifact := fmt.Sprintf("linux-headers-%s-%s.tar.xz", unameR, runtime.GOARCH)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(headersManifest{
+			Artifacts: map[string]string{artifact: sum},
+		})
+	})
+	mux.HandleFunc("/"+artifact, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archiveBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	origURL := HeadersMirrorURL
+	HeadersMirrorURL = server.URL
+	defer func() { HeadersMirrorURL = origURL }()
+
+	cacheDir := filepath.Join(t.TempDir(), "linux-headers-9.9.9-generic")
+	// expect a different version than the tarball actually carries
+	_, err = downloadHeaderDirsInto(cacheDir, Version(999999), "", unameR)
+	assert.Error(t, err)
+}
+
+func TestSelectHeadersArtifactFallsBackToDistroSpecificName(t *testing.T) {
+	manifest := &headersManifest{
+		Artifacts: map[string]string{
+			"linux-headers-debian-5.2.0-amd64.tar.xz": "deadbeef",
+		},
+	}
+	artifact, sum, err := selectHeadersArtifact(manifest, "debian", "5.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "linux-headers-debian-5.2.0-amd64.tar.xz", artifact)
+	assert.Equal(t, "deadbeef", sum)
+}
+
+func TestCandidateHeaderArtifactsPrefersGenericName(t *testing.T) {
+	candidates := candidateHeaderArtifacts("debian", "5.2.0")
+	require.Len(t, candidates, 2)
+	assert.Equal(t, "linux-headers-5.2.0-"+runtime.GOARCH+".tar.xz", candidates[0])
+	assert.Equal(t, "linux-headers-debian-5.2.0-"+runtime.GOARCH+".tar.xz", candidates[1])
+}
+
+func TestSelectHeadersArtifactNoMatch(t *testing.T) {
+	manifest := &headersManifest{Artifacts: map[string]string{}}
+	_, _, err := selectHeadersArtifact(manifest, "debian", "5.2.0")
+	assert.Error(t, err)
+}